@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+func compareFiles() {
+	var sizeA, sizeB, changed, firstDiff int64
+
+	err := withRetry("comparing "+args.Positional.BaseFile+" and "+args.Positional.OtherFile, func() error {
+		one, err := os.Open(args.Positional.BaseFile)
+		if err != nil {
+			return err
+		}
+
+		defer one.Close()
+
+		two, err := os.Open(args.Positional.OtherFile)
+		if err != nil {
+			return err
+		}
+
+		defer two.Close()
+
+		// start over on every attempt
+		sizeA, sizeB, changed, firstDiff = 0, 0, 0, -1
+
+		// walk both files side by side comparing bytes at the same offset, no
+		// diffing involved so this stays fast (and light on memory) even for
+		// huge files
+		a := bufio.NewReaderSize(one, 1<<16)
+		b := bufio.NewReaderSize(two, 1<<16)
+
+		for {
+			x, errA := a.ReadByte()
+			if errA != nil && errA != io.EOF {
+				return errA
+			}
+
+			y, errB := b.ReadByte()
+			if errB != nil && errB != io.EOF {
+				return errB
+			}
+
+			if errA == io.EOF && errB == io.EOF {
+				return nil
+			}
+
+			if errA == nil {
+				sizeA++
+			}
+			if errB == nil {
+				sizeB++
+			}
+
+			// a byte only present in one of the files counts as changed too
+			if errA != nil || errB != nil || x != y {
+				if firstDiff == -1 {
+					firstDiff = sizeA - 1
+					if errA != nil {
+						firstDiff = sizeA
+					}
+				}
+				changed++
+			}
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	phaseDone("compare")
+	noteSize("base size", int(sizeA))
+	noteSize("other size", int(sizeB))
+
+	fmt.Printf("%s: %d bytes\n", args.Positional.BaseFile, sizeA)
+	fmt.Printf("%s: %d bytes\n", args.Positional.OtherFile, sizeB)
+
+	if changed == 0 {
+		fmt.Println("files are identical")
+		return
+	}
+
+	longest := sizeA
+	if sizeB > longest {
+		longest = sizeB
+	}
+
+	similarity := 100 * float64(longest-changed) / float64(longest)
+
+	// these are byte for byte at the same offset, a single insert near the
+	// start makes everything after it count, diff gives the real picture
+	fmt.Println("files differ")
+	fmt.Printf("  first difference at offset:     %d (0x%x)\n", firstDiff, firstDiff)
+	fmt.Printf("  bytes differing at same offset: %d\n", changed)
+	fmt.Printf("  same offset similarity:         %.2f%%\n", similarity)
+
+	exit(exitFilesDiffer)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// where in the base a modification does its work
+func modEnd(m Modification) int {
+	return m.Location + m.Delete
+}
+
+// two modifications of the same base step on each other when the bytes they
+// delete overlap or when they both change the same spot, since then there's
+// no telling which should come first
+func modsConflict(a Modification, b Modification) bool {
+	if a.Location == b.Location {
+		return true
+	}
+
+	return a.Location < modEnd(b) && b.Location < modEnd(a)
+}
+
+func sameMod(a Modification, b Modification) bool {
+	return a.Location == b.Location && a.Delete == b.Delete && bytes.Equal(a.Insert, b.Insert)
+}
+
+func findConflicts() {
+	rawOne := readFile(args.Positional.BaseFile)
+	rawTwo := readFile(args.Positional.OtherFile)
+	one := decodePatch(rawOne)
+	two := decodePatch(rawTwo)
+	phaseDone("read patches")
+	noteSize(args.Positional.BaseFile+" size", len(rawOne))
+	noteSize(args.Positional.OtherFile+" size", len(rawTwo))
+
+	one.Modifications = checkModifications(one.Modifications, -1, args.Positional.BaseFile)
+	two.Modifications = checkModifications(two.Modifications, -1, args.Positional.OtherFile)
+
+	if !bytes.Equal(one.Hash, two.Hash) {
+		fmt.Println("patches were not built from the same base")
+		fmt.Printf("  %s expects base hash %x\n", args.Positional.BaseFile, one.Hash)
+		fmt.Printf("  %s expects base hash %x\n", args.Positional.OtherFile, two.Hash)
+		if !args.Force {
+			exit(1)
+		}
+		fmt.Println("forcing through it")
+	}
+
+	regions, agreed := sweepConflicts(one.Modifications, two.Modifications)
+	phaseDone("find conflicts")
+
+	for _, r := range regions {
+		if r[0] == r[1] {
+			fmt.Printf("conflict: both patches insert at base offset %d\n", r[0])
+		} else {
+			fmt.Printf("conflict: both patches change base bytes %d-%d\n", r[0], r[1]-1)
+		}
+	}
+
+	if len(regions) == 0 {
+		fmt.Println("no conflicts")
+	} else {
+		fmt.Printf("%d conflicting regions found\n", len(regions))
+	}
+
+	// with an output name, keep everything both patches can agree on
+	if len(args.Output) != 0 {
+		merged := Patch{Hash: one.Hash, Path: one.Path, Size: one.Size, Modifications: agreed}
+
+		// a target name only makes sense if both patches agree on it
+		if one.Target == two.Target {
+			merged.Target = one.Target
+		}
+		labelPatch(&merged)
+
+		output := encodePatch(merged)
+		writeOutput(args.Output, output)
+		phaseDone("write")
+		noteSize("patch size", len(output))
+
+		fmt.Printf("wrote %d non-conflicting modifications to %s\n", len(merged.Modifications), args.Output)
+	}
+
+	if len(regions) != 0 {
+		exit(exitConflicts)
+	}
+}
+
+// finds the base regions (end exclusive) where a and b conflict, along with
+// every modification outside of them, sorted, with changes both made kept once
+func sweepConflicts(a []Modification, b []Modification) ([][2]int, []Modification) {
+	badA := make([]bool, len(a))
+	badB := make([]bool, len(b))
+	duplicate := make([]bool, len(b))
+	var pairs [][2]int
+
+	// both lists are sorted, so for each modification in a only the window
+	// of b that starts before it ends and ends after it starts can collide.
+	// the window only ever moves forward
+	start := 0
+	for i := range a {
+		for start < len(b) && modEnd(b[start]) < a[i].Location {
+			start++
+		}
+
+		for j := start; j < len(b) && b[j].Location <= modEnd(a[i]); j++ {
+			if sameMod(a[i], b[j]) {
+				// both patches made the exact same change, that's fine
+				duplicate[j] = true
+				continue
+			}
+
+			if !modsConflict(a[i], b[j]) {
+				continue
+			}
+
+			badA[i], badB[j] = true, true
+
+			lo, hi := a[i].Location, modEnd(a[i])
+			if b[j].Location < lo {
+				lo = b[j].Location
+			}
+			if modEnd(b[j]) > hi {
+				hi = modEnd(b[j])
+			}
+			pairs = append(pairs, [2]int{lo, hi})
+		}
+	}
+
+	// neighboring conflicts get reported as one region
+	sort.Slice(pairs, func(x, y int) bool {
+		return pairs[x][0] < pairs[y][0]
+	})
+
+	var regions [][2]int
+	for _, p := range pairs {
+		last := len(regions) - 1
+		if last >= 0 && p[0] <= regions[last][1] {
+			if p[1] > regions[last][1] {
+				regions[last][1] = p[1]
+			}
+		} else {
+			regions = append(regions, p)
+		}
+	}
+
+	var agreed []Modification
+	for k, m := range a {
+		if !badA[k] {
+			agreed = append(agreed, m)
+		}
+	}
+	for k, m := range b {
+		if !badB[k] && !duplicate[k] {
+			agreed = append(agreed, m)
+		}
+	}
+
+	sort.SliceStable(agreed, func(x, y int) bool {
+		return agreed[x].Location < agreed[y].Location
+	})
+
+	return regions, agreed
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSweepConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       []Modification
+		b       []Modification
+		regions [][2]int
+		agreed  []Modification
+	}{
+		{
+			name: "both empty",
+		},
+		{
+			name:   "one side empty",
+			a:      []Modification{{Location: 3, Delete: 1}},
+			agreed: []Modification{{Location: 3, Delete: 1}},
+		},
+		{
+			name: "far apart",
+			a:    []Modification{{Location: 2, Delete: 2, Insert: []byte("x")}},
+			b:    []Modification{{Location: 10, Insert: []byte("y")}},
+			agreed: []Modification{
+				{Location: 2, Delete: 2, Insert: []byte("x")},
+				{Location: 10, Insert: []byte("y")},
+			},
+		},
+		{
+			name: "touching but not overlapping",
+			a:    []Modification{{Location: 2, Delete: 3}},
+			b:    []Modification{{Location: 5, Insert: []byte("y")}},
+			agreed: []Modification{
+				{Location: 2, Delete: 3},
+				{Location: 5, Insert: []byte("y")},
+			},
+		},
+		{
+			name:    "overlapping deletes",
+			a:       []Modification{{Location: 2, Delete: 4}},
+			b:       []Modification{{Location: 4, Delete: 4, Insert: []byte("y")}},
+			regions: [][2]int{{2, 8}},
+		},
+		{
+			name:    "inserts at the same spot",
+			a:       []Modification{{Location: 4, Insert: []byte("x")}},
+			b:       []Modification{{Location: 4, Insert: []byte("y")}},
+			regions: [][2]int{{4, 4}},
+		},
+		{
+			name:    "insert inside a delete",
+			a:       []Modification{{Location: 2, Delete: 6}},
+			b:       []Modification{{Location: 5, Insert: []byte("y")}},
+			regions: [][2]int{{2, 8}},
+		},
+		{
+			name:   "identical changes",
+			a:      []Modification{{Location: 1, Delete: 1, Insert: []byte("z")}},
+			b:      []Modification{{Location: 1, Delete: 1, Insert: []byte("z")}},
+			agreed: []Modification{{Location: 1, Delete: 1, Insert: []byte("z")}},
+		},
+		{
+			name:    "insert at the end of matching deletes",
+			a:       []Modification{{Location: 5, Insert: []byte("x")}},
+			b:       []Modification{{Location: 2, Delete: 3}, {Location: 5, Insert: []byte("y")}},
+			regions: [][2]int{{5, 5}},
+			agreed:  []Modification{{Location: 2, Delete: 3}},
+		},
+		{
+			name:    "insert at the end of matching deletes mirrored",
+			a:       []Modification{{Location: 2, Delete: 3}, {Location: 5, Insert: []byte("y")}},
+			b:       []Modification{{Location: 5, Insert: []byte("x")}},
+			regions: [][2]int{{5, 5}},
+			agreed:  []Modification{{Location: 2, Delete: 3}},
+		},
+		{
+			name: "long delete against several",
+			a: []Modification{
+				{Location: 0, Delete: 10},
+				{Location: 20, Insert: []byte("a")},
+			},
+			b: []Modification{
+				{Location: 2, Delete: 1},
+				{Location: 6, Delete: 6},
+				{Location: 30, Delete: 1},
+			},
+			regions: [][2]int{{0, 12}},
+			agreed: []Modification{
+				{Location: 20, Insert: []byte("a")},
+				{Location: 30, Delete: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		regions, agreed := sweepConflicts(test.a, test.b)
+
+		if !reflect.DeepEqual(regions, test.regions) {
+			t.Errorf("%s: got regions %v, want %v", test.name, regions, test.regions)
+		}
+
+		if !sameMods(agreed, test.agreed) {
+			t.Errorf("%s: got agreed modifications %+v, want %+v", test.name, agreed, test.agreed)
+		}
+	}
+}
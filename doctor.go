@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// walks through everything that can keep a patch from applying and says
+// what to do about it, instead of just "hash mismatch"
+func diagnose() {
+	problems := 0
+	problem := func(what string, next string) {
+		problems++
+		fmt.Printf("problem: %s\n", what)
+		fmt.Printf("  try: %s\n", next)
+	}
+
+	patch, ok := decodeForDiagnosis(args.Positional.OtherFile, problem)
+	if !ok {
+		exit(1)
+	}
+	fmt.Printf("ok: %s is a readable patch with %d modifications\n", args.Positional.OtherFile, len(patch.Modifications))
+
+	// same as readAndHash, but a missing base is a finding, not a crash
+	var base []byte
+	err := withRetry("reading "+args.Positional.BaseFile, func() error {
+		var err error
+		base, err = ioutil.ReadFile(args.Positional.BaseFile)
+		return err
+	})
+	if err != nil {
+		problem(fmt.Sprintf("can't read %s: %v", args.Positional.BaseFile, err),
+			"check the path and permissions of BASE_FILE, or run patch with only the patch file to have it find the base")
+		exit(1)
+	}
+
+	phaseDone("read base")
+	noteSize("base size", len(base))
+
+	sum := sha256.Sum256(base)
+	h := sum[:]
+	alreadyPatched := patch.Result != nil && bytes.Equal(patch.Result, h)
+
+	// an already patched file is expected to have a different size
+	if patch.Size != 0 && patch.Size != len(base) && !alreadyPatched {
+		problem(fmt.Sprintf("patch was built from a %d byte file, %s is %d bytes", patch.Size, args.Positional.BaseFile, len(base)),
+			"make sure BASE_FILE is the exact version the patch was made for")
+	}
+
+	if bytes.Equal(patch.Hash, h) {
+		fmt.Println("ok: base hash matches the patch")
+	} else if alreadyPatched {
+		problem(fmt.Sprintf("%s already is the output of this patch", args.Positional.BaseFile),
+			"nothing to do, the file has already been patched")
+	} else if looksPatched(base, patch.Modifications) {
+		problem(fmt.Sprintf("%s looks like it has already been patched", args.Positional.BaseFile),
+			"nothing to do if this file was updated before, otherwise restore the original and patch that")
+	} else {
+		problem(fmt.Sprintf("base hash %x doesn't match the %x the patch expects", h, patch.Hash),
+			"compare `patcher hash BASE_FILE` against the published hashes to find the right patch, or --force at your own risk")
+	}
+
+	err = validateModifications(patch.Modifications, len(base))
+	if err != nil {
+		problem(fmt.Sprintf("modifications don't fit the base: %v", err),
+			"rebuild the patch, or apply with --repair if it can't be rebuilt")
+	}
+
+	phaseDone("checks")
+
+	if problems != 0 {
+		exit(1)
+	}
+
+	fmt.Println("patch should apply cleanly")
+}
+
+// like readPatch but notices the usual ways a patch file gets mangled on its
+// way to the user rather than panicking
+func decodeForDiagnosis(filename string, problem func(string, string)) (Patch, bool) {
+	patch := Patch{}
+
+	var raw []byte
+
+	err := withRetry("reading "+filename, func() error {
+		var err error
+		raw, err = ioutil.ReadFile(filename)
+		return err
+	})
+	if err != nil {
+		problem(fmt.Sprintf("can't read %s: %v", filename, err), "check the path and permissions")
+		return patch, false
+	}
+
+	phaseDone("read patch")
+	noteSize("patch size", len(raw))
+
+	return checkPatchData(filename, raw, problem)
+}
+
+// the decoding half of decodeForDiagnosis, for patch data already in hand
+func checkPatchData(filename string, raw []byte, problem func(string, string)) (Patch, bool) {
+	patch := Patch{}
+
+	if len(raw) == 0 {
+		problem(fmt.Sprintf("%s is empty", filename), "download the patch again")
+		return patch, false
+	}
+
+	data, err := inflate(raw)
+	if err != nil {
+		if json.Valid(raw) {
+			// someone unpacked it already, still usable
+			problem(fmt.Sprintf("%s isn't compressed", filename), "recompress it with zlib or rebuild it with patcher diff")
+			data = raw
+		} else if bytes.HasPrefix(raw, []byte{0x78}) {
+			problem(fmt.Sprintf("%s is truncated or corrupt: %v", filename, err), "download the patch again and compare its checksum")
+			return patch, false
+		} else {
+			problem(fmt.Sprintf("%s isn't a patcher patch file", filename), "check that OTHER_FILE is the .patch file and BASE_FILE the file to update")
+			return patch, false
+		}
+	}
+
+	// compressed twice over, usually an overeager upload or download step
+	inner, err := inflate(data)
+	if err == nil {
+		problem(fmt.Sprintf("%s is compressed twice", filename), "decompress it once or grab the original patch file")
+		data = inner
+	}
+
+	err = json.Unmarshal(data, &patch)
+	if err != nil {
+		problem(fmt.Sprintf("%s doesn't contain patch data: %v", filename, err), "download the patch again or rebuild it")
+		return patch, false
+	}
+
+	return patch, true
+}
+
+func inflate(data []byte) ([]byte, error) {
+	z, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.Close()
+
+	return ioutil.ReadAll(z)
+}
+
+// an already patched file has every insert sitting where the patch would
+// have put it
+func looksPatched(file []byte, mods []Modification) bool {
+	shift := 0
+	found := 0
+	for _, m := range mods {
+		at := m.Location + shift
+		if len(m.Insert) != 0 {
+			if at < 0 || at+len(m.Insert) > len(file) || !bytes.Equal(file[at:at+len(m.Insert)], m.Insert) {
+				return false
+			}
+			found++
+		}
+		shift += len(m.Insert) - m.Delete
+	}
+
+	return found != 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// prints the same digest diff embeds in a patch for every file given, in
+// sha256sum's format so it can be compared against published checksums
+// directly
+func printHash() {
+	files := []string{args.Positional.BaseFile}
+	if len(args.Positional.OtherFile) != 0 {
+		files = append(files, args.Positional.OtherFile)
+	}
+	files = append(files, args.Positional.Extra...)
+
+	for _, file := range files {
+		h, size := hashFile(file)
+		phaseDone("hash " + file)
+		noteSize(file+" size", int(size))
+
+		fmt.Printf("%x  %s\n", h, file)
+	}
+}
+
+// hashes a file without holding all of it in memory, also returns its size
+func hashFile(filename string) ([]byte, int64) {
+	hasher := sha256.New()
+	var size int64
+
+	err := withRetry("reading "+filename, func() error {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		// start over on every attempt
+		hasher.Reset()
+
+		size, err = io.Copy(hasher, f)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return hasher.Sum(nil), size
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+func printInfo() {
+	raw := readFile(args.Positional.BaseFile)
+	patch := decodePatch(raw)
+	phaseDone("read patch")
+	noteSize("patch size", len(raw))
+
+	inserted, deleted := 0, 0
+	for _, m := range patch.Modifications {
+		inserted += len(m.Insert)
+		deleted += m.Delete
+	}
+
+	if len(patch.Path) != 0 {
+		fmt.Printf("base path:     %s\n", patch.Path)
+	}
+	if len(patch.Target) != 0 {
+		fmt.Printf("target path:   %s\n", patch.Target)
+	}
+	fmt.Printf("base hash:     %x\n", patch.Hash)
+	if patch.Size != 0 {
+		fmt.Printf("base size:     %d bytes\n", patch.Size)
+	}
+	if patch.Result != nil {
+		fmt.Printf("result hash:   %x\n", patch.Result)
+	}
+	fmt.Printf("modifications: %d\n", len(patch.Modifications))
+	fmt.Printf("bytes deleted: %d\n", deleted)
+	fmt.Printf("bytes added:   %d\n", inserted)
+
+	if len(patch.Message) != 0 {
+		fmt.Printf("message:       %s\n", patch.Message)
+	}
+
+	if len(patch.Labels) != 0 {
+		// map order is random, keep the output stable
+		keys := make([]string, 0, len(patch.Labels))
+		for k := range patch.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Println("labels:")
+		for _, k := range keys {
+			fmt.Printf("  %s=%s\n", k, patch.Labels[k])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the path stored in a patch for its base, relative and with forward slashes
+// so it means the same thing on every platform
+func recordedPath(base string) string {
+	p := base
+	if len(args.BasePath) != 0 {
+		p = args.BasePath
+	} else if filepath.IsAbs(p) {
+		cwd, err := os.Getwd()
+		if err == nil {
+			if rel, err := filepath.Rel(cwd, p); err == nil {
+				p = rel
+			}
+		}
+	}
+
+	p = filepath.ToSlash(filepath.Clean(p))
+
+	// anything outside of where diff ran from is meaningless elsewhere,
+	// the name is the best that can be done
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		p = path.Base(p)
+	}
+
+	return p
+}
+
+// the target's name placed next to the recorded base path, that's where it
+// ends up when patch locates the base itself
+func recordedTarget(basePath string, target string) string {
+	return path.Join(path.Dir(basePath), filepath.Base(target))
+}
+
+// where patch writes when it located the base itself, the recorded target
+// name in the base's directory. patches without one update the base in place
+func locatedOutput(patch Patch, baseFile string) string {
+	if len(patch.Target) == 0 {
+		return baseFile
+	}
+
+	// only the name is used, the directory is wherever the base was found
+	name := path.Base(patch.Target)
+	if name == "." || name == ".." || name == "/" {
+		fmt.Printf("patch records an unsafe target %q, give --out explicitly\n", patch.Target)
+		exit(1)
+	}
+
+	return filepath.Join(filepath.Dir(baseFile), name)
+}
+
+// finds the file a patch was built from, starting with its recorded path and
+// falling back to searching below the current directory for a file with the
+// same name
+func locateBase(patch Patch) string {
+	if len(patch.Path) == 0 {
+		fmt.Println("patch doesn't record which file it applies to, give BASE_FILE explicitly")
+		exit(1)
+	}
+
+	// the path comes from the patch, don't let it point anywhere it shouldn't
+	clean := path.Clean(patch.Path)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		fmt.Printf("patch records an unsafe path %q, give BASE_FILE explicitly\n", patch.Path)
+		exit(1)
+	}
+
+	candidate := filepath.FromSlash(clean)
+	if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+		return candidate
+	}
+
+	name := path.Base(clean)
+	var matches []string
+	err := withRetry("searching for "+name, func() error {
+		// start over on every attempt
+		matches = nil
+
+		return filepath.Walk(".", func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				// transient errors restart the search, unreadable corners
+				// of the tree otherwise aren't worth failing over
+				if retryable(err) {
+					return err
+				}
+				return nil
+			}
+
+			if !info.IsDir() && info.Name() == name && (patch.Size == 0 || info.Size() == int64(patch.Size)) {
+				matches = append(matches, p)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// several files by that name, only the one with the right contents will do
+	var exact []string
+	for _, m := range matches {
+		if h, _ := hashFile(m); bytes.Equal(h, patch.Hash) {
+			exact = append(exact, m)
+		}
+	}
+
+	switch {
+	case len(exact) == 1:
+		return exact[0]
+	case len(exact) == 0 && len(matches) == 1:
+		// let the usual hash check deal with it
+		return matches[0]
+	case len(matches) == 0:
+		fmt.Printf("couldn't find %s below the current directory, give BASE_FILE explicitly\n", patch.Path)
+	default:
+		fmt.Printf("found several candidates for %s, give BASE_FILE explicitly:\n", patch.Path)
+		for _, m := range matches {
+			fmt.Printf("  %s\n", m)
+		}
+	}
+
+	exit(1)
+	return ""
+}
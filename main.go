@@ -10,7 +10,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mb0/diff"
@@ -18,8 +20,14 @@ import (
 
 // base model of the patch file that's JSON encoded and then compressed
 type Patch struct {
-	Hash          []byte         `json:"H"`
-	Modifications []Modification `json:"M"`
+	Hash          []byte            `json:"H"`
+	Modifications []Modification    `json:"M"`
+	Path          string            `json:"P,omitempty"`
+	Target        string            `json:"O,omitempty"`
+	Size          int               `json:"S,omitempty"`
+	Result        []byte            `json:"R,omitempty"`
+	Message       string            `json:"C,omitempty"`
+	Labels        map[string]string `json:"T,omitempty"`
 }
 
 // each modification with a slim json output
@@ -30,24 +38,58 @@ type Modification struct {
 }
 
 type PositionalFiles struct {
-	Action    string `positional-arg-name:"ACTION" required:"true"`
-	BaseFile  string `positional-arg-name:"BASE_FILE" required:"true"`
-	OtherFile string `positional-arg-name:"OTHER_FILE" required:"true"`
+	Action    string   `positional-arg-name:"ACTION" required:"true"`
+	BaseFile  string   `positional-arg-name:"BASE_FILE" required:"true"`
+	OtherFile string   `positional-arg-name:"OTHER_FILE"`
+	Extra     []string `positional-arg-name:"MORE_FILES"`
 }
 
 type Arguments struct {
-	Output     string          `short:"o" long:"out" description:"output name"`
-	Force      bool            `short:"f" long:"force" description:"force the patch even if target integrity check fails"`
-	Positional PositionalFiles `positional-args:"true"`
+	Output       string          `short:"o" long:"out" description:"output name"`
+	Message      string          `short:"m" long:"message" description:"message to store in the patch"`
+	Labels       []string        `short:"l" long:"label" description:"key=value label to store in the patch, can be repeated"`
+	BasePath     string          `long:"base-path" description:"path to record for BASE_FILE in the patch, relative to where patch will be run"`
+	OutDir       string          `long:"out-dir" description:"directory to write outputs into"`
+	Force        bool            `short:"f" long:"force" description:"force the patch even if target integrity check fails"`
+	MaxPatch     string          `long:"max-patch-size" description:"fail diff if the patch is bigger than this size (e.g. 512K, 10MB) or percentage of OTHER_FILE (e.g. 25%)"`
+	Repair       bool            `long:"repair" description:"fix up patches with unsorted, overlapping or out of bounds modifications instead of rejecting them"`
+	Checksum     bool            `long:"checksum" description:"also write a sha256sum compatible <output>.sha256 file for every output"`
+	Summary      bool            `long:"summary" description:"print timings and sizes once done"`
+	Retries      int             `long:"retries" default:"0" description:"how many times to retry reads and writes that fail with a transient error"`
+	RetryBackoff time.Duration   `long:"retry-backoff" default:"500ms" description:"wait before the first retry, doubled after each one"`
+	RetryOn      string          `long:"retry-on" default:"EIO,EAGAIN,EINTR,ETIMEDOUT,ESTALE" description:"comma separated errors worth retrying (EAGAIN, EBUSY, ECONNRESET, EINTR, EIO, ESTALE, ETIMEDOUT)"`
+	Strict       bool            `long:"strict" description:"verify the whole patch against the base, and the output against the patch, before writing anything"`
+	Simulate     bool            `short:"n" long:"simulate" description:"report the size and hash the patched output would have without writing it, exits with 5 if the base or output hash is wrong"`
+	Positional   PositionalFiles `positional-args:"true"`
 }
 
 var args Arguments
 
+// exit codes beyond the generic 1 (usage) and 2 (panic) so scripts can tell
+// policy failures apart from actual errors
+const (
+	exitPatchTooLarge = 3
+	exitConflicts     = 4
+	exitInvalidPatch  = 5
+	exitFilesDiffer   = 6
+)
+
 func printExtendedUsage() {
 	// adding details about the ACTION variable
 	fmt.Println("Action Options:")
 	fmt.Println("  diff          Create a diff file that can convert BASE_FILE to OTHER_FILE")
-	fmt.Println("  patch         Update the BASE_FILE using the diff file in OTHER_FILE")
+	fmt.Println("                (or one per file when MORE_FILES are given)")
+	fmt.Println("  patch         Update the BASE_FILE using the diff file in OTHER_FILE, with only")
+	fmt.Println("                the diff file the base is found from its recorded path and the output")
+	fmt.Println("                is written next to it under the recorded target name")
+	fmt.Println("  compare       Summarize how much BASE_FILE and OTHER_FILE differ byte for byte without")
+	fmt.Println("                building a diff, exits with 6 when they differ")
+	fmt.Println("  hash          Print the digest diff embeds and patch checks for BASE_FILE (and any other files)")
+	fmt.Println("  info          Describe the patch file BASE_FILE")
+	fmt.Println("  doctor        Explain why the patch file OTHER_FILE won't apply to BASE_FILE")
+	fmt.Println("  merge         Combine consecutive patches BASE_FILE, OTHER_FILE (and MORE_FILES) into one patch")
+	fmt.Println("  conflicts     Report where patches BASE_FILE and OTHER_FILE of the same base collide,")
+	fmt.Println("                with --out also write a patch of their non-conflicting changes")
 }
 
 func main() {
@@ -60,49 +102,187 @@ func main() {
 		panic(err)
 	}
 
+	parseRetryOn()
+	parseLabels()
+
 	// determine which action to do and do it
 	switch strings.ToLower(args.Positional.Action) {
 	case "diff":
+		requireOtherFile()
 		buildDiff()
 	case "patch":
 		applyPatch()
+	case "compare":
+		requireOtherFile()
+		compareFiles()
+	case "hash":
+		printHash()
+	case "info":
+		printInfo()
+	case "doctor":
+		requireOtherFile()
+		diagnose()
+	case "merge":
+		requireOtherFile()
+		mergePatches()
+	case "conflicts":
+		requireOtherFile()
+		findConflicts()
 	default:
 		// don't know what to do
-		fmt.Printf("unknown ACTION: %s, must be either DIFF or PATCH\n", args.Positional.Action)
-		os.Exit(1)
+		fmt.Printf("unknown ACTION: %s, must be one of DIFF, PATCH, COMPARE, HASH, INFO, DOCTOR, MERGE or CONFLICTS\n", args.Positional.Action)
+		exit(1)
 	}
+
+	printSummary()
+}
+
+// most actions work on a pair of files, hash is the odd one out
+func requireOtherFile() {
+	if len(args.Positional.OtherFile) == 0 {
+		fmt.Printf("the required argument OTHER_FILE was not provided for %s\n", args.Positional.Action)
+		exit(1)
+	}
+}
+
+// reads the whole file while computing the digest used for integrity checks
+func readAndHash(filename string) ([]byte, []byte) {
+	data := readFile(filename)
+	h := sha256.Sum256(data)
+
+	return data, h[:]
 }
 
 func buildDiff() {
-	// the base file is the file that we will later apply this diff to
-	f, err := os.Open(args.Positional.BaseFile)
-	if err != nil {
-		panic(err)
+	// check the limit up front rather than after a long diff
+	var limit sizeLimit
+	if len(args.MaxPatch) != 0 {
+		var err error
+		limit, err = parseSizeLimit(args.MaxPatch)
+		if err != nil {
+			fmt.Printf("--max-patch-size: %v, expected something like 512K, 1.5MB or 25%%\n", err)
+			exit(1)
+		}
 	}
 
-	defer f.Close()
+	targets := append([]string{args.Positional.OtherFile}, args.Positional.Extra...)
+	if len(targets) > 1 && len(args.Output) != 0 {
+		fmt.Println("--out only works with a single OTHER_FILE, use --out-dir for several")
+		exit(1)
+	}
 
-	// hash the file as we read it for the integrity check
-	hasher := sha256.New()
-	t := io.TeeReader(f, hasher)
+	filenames := diffOutputNames(targets)
 
-	one, err := ioutil.ReadAll(t)
-	if err != nil {
-		panic(err)
+	// the base file is the file that we will later apply this diff to,
+	// hash it as we read it for the integrity check. it's only read once no
+	// matter how many targets there are
+	one, h := readAndHash(args.Positional.BaseFile)
+	phaseDone("read base")
+	noteSize("base size", len(one))
+
+	tooLarge := false
+	for i, target := range targets {
+		two := readFile(target)
+
+		phaseDone("read " + target)
+		noteSize(target+" size", len(two))
+
+		patch := makePatch(one, h, two)
+		patch.Path = recordedPath(args.Positional.BaseFile)
+		patch.Target = recordedTarget(patch.Path, target)
+		labelPatch(&patch)
+		phaseDone("diff " + target)
+
+		// never write out something patch would refuse
+		err := validateModifications(patch.Modifications, len(one))
+		if err != nil {
+			panic(fmt.Errorf("diff produced an invalid patch for %s: %w", target, err))
+		}
+
+		output := encodePatch(patch)
+		phaseDone("encode " + target)
+		noteSize(target+" patch size", len(output))
+		notePatchRatio(target+" compression", len(output), len(two))
+
+		if len(args.MaxPatch) != 0 {
+			max := limit.of(int64(len(two)))
+			if int64(len(output)) > max {
+				// keep going so a single run reports every offender
+				fmt.Printf("%s: patch is %d bytes, over the --max-patch-size limit of %d bytes\n", target, len(output), max)
+				tooLarge = true
+				continue
+			}
+		}
+
+		filename := filenames[i]
+
+		err = os.MkdirAll(filepath.Dir(filename), 0777)
+		if err != nil {
+			panic(err)
+		}
+
+		writeOutput(filename, output)
+		phaseDone("write " + filename)
 	}
 
-	h := hasher.Sum(nil)
+	if tooLarge {
+		exit(exitPatchTooLarge)
+	}
+}
 
-	two, err := ioutil.ReadFile(args.Positional.OtherFile)
-	if err != nil {
-		panic(err)
+// picks where each target's patch goes. a plain single target diff keeps
+// the original <base>.patch name. with MORE_FILES or --out-dir patches are
+// named after their target so patch can strip the suffix and end up with the
+// target's name, targets sharing a name (v2/app.bin, v3/app.bin) get a
+// directory each
+func diffOutputNames(targets []string) []string {
+	if len(args.Output) != 0 {
+		return []string{filepath.Join(args.OutDir, args.Output)}
+	}
+
+	if len(targets) == 1 && len(args.OutDir) == 0 {
+		return []string{filepath.Base(args.Positional.BaseFile) + ".patch"}
+	}
+
+	counts := map[string]int{}
+	for _, target := range targets {
+		counts[filepath.Base(target)]++
+	}
+
+	filenames := make([]string, len(targets))
+	seen := map[string]string{}
+	for i, target := range targets {
+		name := filepath.Base(target) + ".patch"
+		if counts[filepath.Base(target)] > 1 {
+			name = filepath.Join(filepath.Base(filepath.Dir(target)), name)
+		}
+		filenames[i] = filepath.Join(args.OutDir, name)
+
+		// checked before anything gets written, no silently replacing one
+		// target's patch with another's
+		if other, ok := seen[filenames[i]]; ok {
+			fmt.Printf("%s and %s would both be written to %s\n", other, target, filenames[i])
+			exit(1)
+		}
+		seen[filenames[i]] = target
 	}
 
+	return filenames
+}
+
+// builds the patch that turns one (whose hash is h) into two
+func makePatch(one []byte, h []byte, two []byte) Patch {
 	changes := diff.Bytes(one, two) // where the magic happens
 
+	// the base size and the hash of the result aren't needed to apply the
+	// patch, they're there to help figure out why one won't
+	result := sha256.Sum256(two)
+
 	patch := Patch{
 		Hash:          h,
 		Modifications: make([]Modification, len(changes)),
+		Size:          len(one),
+		Result:        result[:],
 	}
 	for i, c := range changes { // where the other magic happens
 		mod := Modification{
@@ -119,29 +299,58 @@ func buildDiff() {
 		patch.Modifications[i] = mod
 	}
 
-	output, err := json.Marshal(patch)
-	if err != nil {
-		panic(err)
+	return patch
+}
+
+// the --label values, filled in by parseLabels
+var labels map[string]string
+
+// checks the --label values up front so a typo doesn't cost a whole diff
+func parseLabels() {
+	labels = nil
+
+	for _, label := range args.Labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			fmt.Printf("invalid label %q, must be key=value\n", label)
+			exit(1)
+		}
+
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[kv[0]] = kv[1]
 	}
+}
 
-	filename := args.Output
+// attaches the --message and --label metadata to a patch, anything not
+// given on the command line is left alone
+func labelPatch(patch *Patch) {
+	if len(args.Message) != 0 {
+		patch.Message = args.Message
+	}
 
-	if len(filename) == 0 {
-		_, filename = filepath.Split(args.Positional.BaseFile)
-		filename = filename + ".patch"
+	for k, v := range labels {
+		if patch.Labels == nil {
+			patch.Labels = map[string]string{}
+		}
+		patch.Labels[k] = v
 	}
+}
 
-	out, err := os.Create(filename)
+// JSON encodes and compresses a patch, ready to be written out
+func encodePatch(patch Patch) []byte {
+	raw, err := json.Marshal(patch)
 	if err != nil {
 		panic(err)
 	}
 
-	defer out.Close()
+	var buf bytes.Buffer
 
 	// compress it
-	z := zlib.NewWriter(out)
+	z := zlib.NewWriter(&buf)
 
-	_, err = z.Write(output)
+	_, err = z.Write(raw)
 	if err != nil {
 		panic(err)
 	}
@@ -150,91 +359,284 @@ func buildDiff() {
 	if err != nil {
 		panic(err)
 	}
+
+	return buf.Bytes()
 }
 
-func applyPatch() {
-	// the base file will receive modifications
-	f, err := os.Open(args.Positional.BaseFile)
+// a --max-patch-size limit, either absolute or relative to the target
+type sizeLimit struct {
+	bytes    int64
+	percent  float64
+	relative bool
+}
+
+// turns a limit like "25%", "512K", "1.5M" or "10MB" into a sizeLimit
+func parseSizeLimit(limit string) (sizeLimit, error) {
+	s := strings.ToUpper(strings.TrimSpace(limit))
+
+	if strings.HasSuffix(s, "%") {
+		pct, err := parseDecimal(strings.TrimSuffix(s, "%"))
+		if err != nil {
+			return sizeLimit{}, fmt.Errorf("invalid size limit %q", limit)
+		}
+
+		return sizeLimit{percent: pct, relative: true}, nil
+	}
+
+	// at most one unit, optionally followed by B
+	multiplier := float64(1)
+	s = strings.TrimSuffix(s, "B")
+	if len(s) != 0 {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := parseDecimal(s)
 	if err != nil {
-		panic(err)
+		return sizeLimit{}, fmt.Errorf("invalid size limit %q", limit)
+	}
+
+	return sizeLimit{bytes: int64(n * multiplier)}, nil
+}
+
+// plain decimal numbers only, ParseFloat on its own also takes things like
+// inf, nan, 1e9 and 0x10
+func parseDecimal(s string) (float64, error) {
+	digits, dots := 0, 0
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.':
+			dots++
+		default:
+			return 0, fmt.Errorf("%q is not a decimal number", s)
+		}
 	}
 
-	defer f.Close()
+	if digits == 0 || dots > 1 {
+		return 0, fmt.Errorf("%q is not a decimal number", s)
+	}
 
-	// hash to verify
-	hasher := sha256.New()
-	t := io.TeeReader(f, hasher)
+	return strconv.ParseFloat(s, 64)
+}
 
-	base, err := ioutil.ReadAll(t)
-	if err != nil {
-		panic(err)
+// the limit in bytes for a target of total bytes
+func (l sizeLimit) of(total int64) int64 {
+	if l.relative {
+		return int64(float64(total) * l.percent / 100)
 	}
 
-	h := hasher.Sum(nil)
+	return l.bytes
+}
 
-	// the other file should be the patch file
-	other, err := os.Open(args.Positional.OtherFile)
-	if err != nil {
-		panic(err)
+func applyPatch() {
+	baseFile, patchFile := args.Positional.BaseFile, args.Positional.OtherFile
+	if len(patchFile) == 0 {
+		// only given the patch, it knows where its base lives
+		baseFile, patchFile = "", baseFile
 	}
 
-	z, err := zlib.NewReader(other)
-	if err != nil {
-		panic(err)
+	raw := readFile(patchFile)
+
+	// with a single argument, make sure it's a patch and not a forgotten
+	// PATCH_FILE before trying to decode it
+	if len(baseFile) == 0 {
+		var found []string
+		_, ok := checkPatchData(patchFile, raw, func(what string, next string) {
+			found = append(found, what)
+		})
+		if !ok || len(found) != 0 {
+			fmt.Printf("%s doesn't look like a patch file: %s\n", patchFile, found[0])
+			fmt.Println("use `patch BASE_FILE PATCH_FILE`, or `patch PATCH_FILE` to find the base from the patch")
+			exit(1)
+		}
 	}
 
-	rawJson, err := ioutil.ReadAll(z)
-	if err != nil {
-		panic(err)
+	patch := decodePatch(raw)
+	phaseDone("read patch")
+	noteSize("patch size", len(raw))
+
+	if len(baseFile) == 0 {
+		baseFile = locateBase(patch)
+		phaseDone("locate base")
 	}
 
-	patch := Patch{}
+	// the base file will receive modifications, hash to verify
+	base, h := readAndHash(baseFile)
+	phaseDone("read base")
+	noteSize("base size", len(base))
 
-	err = json.Unmarshal(rawJson, &patch)
-	if err != nil {
-		panic(err)
+	if args.Strict {
+		if args.Force || args.Repair {
+			fmt.Println("--strict can't be combined with --force or --repair")
+			exit(1)
+		}
+
+		strictCheck(patch, base, h)
+		phaseDone("strict checks")
 	}
 
 	// check the hash and stop... unless forced
 	if !bytes.Equal(patch.Hash, h) {
+		// show both digests so they can be checked against `patcher hash`
+		fmt.Printf("patch expects base hash %x\n", patch.Hash)
+		fmt.Printf("BASE_FILE has hash      %x\n", h)
 		if args.Force {
 			fmt.Println("hash mismtach, forcing through it")
 		} else {
 			fmt.Println("hash mismatch, giving up")
+			if args.Simulate {
+				// a pre-check that can't vouch for the output has failed
+				exit(exitInvalidPatch)
+			}
 			return
 		}
 	}
 
-	var output []byte
+	patch.Modifications = checkModifications(patch.Modifications, len(base), patchFile)
+
+	filename := args.Output
 
-	index := 0
-	for loc := 0; loc < len(base); loc++ {
-		if index == len(patch.Modifications) || loc != patch.Modifications[index].Location {
-			output = append(output, base[loc])
-			continue
+	if len(filename) == 0 && len(args.Positional.OtherFile) == 0 {
+		// a located base gets replaced by the recorded target, next to it
+		filename = locatedOutput(patch, baseFile)
+		fmt.Printf("patching %s into %s\n", baseFile, filename)
+	} else if len(filename) == 0 {
+		// attempt to remove the file extension from the patch file
+		// if the patch file wasn't named with the expected suffix, or
+		// stripping it would land on the base itself, prepend [PATCHED]
+		// to the base file name
+		_, patchfilename := filepath.Split(patchFile)
+		filename = strings.TrimSuffix(patchfilename, ".patch")
+		if filename == patchfilename || sameFile(filename, baseFile) {
+			filename = "[PATCHED]" + filepath.Base(baseFile)
 		}
+	}
 
-		loc += patch.Modifications[index].Delete
-		output = append(output, patch.Modifications[index].Insert...)
-		index++
-		loc--
+	if args.Simulate {
+		// run the modifications through the hasher instead of a file, the
+		// output never has to exist to know what it would look like
+		hasher := sha256.New()
+		counter := &countingWriter{w: hasher}
+
+		writeModified(counter, base, patch.Modifications)
+		phaseDone("simulate")
+		noteSize("output size", int(counter.n))
+		notePatchRatio("compression", len(raw), int(counter.n))
+
+		result := hasher.Sum(nil)
+
+		fmt.Printf("predicted output size: %d bytes\n", counter.n)
+		fmt.Printf("predicted output hash: %x  %s\n", result, filename)
+
+		// older patches don't record what they produce
+		if patch.Result != nil {
+			if !bytes.Equal(result, patch.Result) {
+				fmt.Printf("predicted output doesn't match the result hash %x recorded in the patch\n", patch.Result)
+				exit(exitInvalidPatch)
+			}
+			fmt.Println("predicted output matches the result hash recorded in the patch")
+		}
+		return
 	}
 
-	filename := args.Output
+	var output bytes.Buffer
+	writeModified(&output, base, patch.Modifications)
+	phaseDone("apply")
+	noteSize("output size", output.Len())
+	notePatchRatio("compression", len(raw), output.Len())
 
-	if len(filename) == 0 {
-		// attempt to remove the file extension from the patch file
-		// if the patch file wasn't named with the expected suffix
-		// prepend [PATCHED] to the patch file name
-		_, patchfilename := filepath.Split(args.Positional.BaseFile)
-		filename = strings.TrimSuffix(patchfilename, ".patch")
-		if filename == patchfilename {
-			filename = "[PATCHED]" + filename
+	writeOutput(filename, output.Bytes())
+	phaseDone("write")
+}
+
+// whether both names point at the same existing file
+func sameFile(a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(infoA, infoB)
+}
+
+// decompresses and decodes a patch file
+func readPatch(filename string) Patch {
+	return decodePatch(readFile(filename))
+}
+
+// decompresses and decodes the contents of a patch file
+func decodePatch(raw []byte) Patch {
+	z, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		panic(err)
+	}
+
+	rawJson, err := ioutil.ReadAll(z)
+	if err != nil {
+		panic(err)
+	}
+
+	patch := Patch{}
+
+	err = json.Unmarshal(rawJson, &patch)
+	if err != nil {
+		panic(err)
+	}
+
+	return patch
+}
+
+// streams the base with the modifications applied into w, modifications are
+// expected to be sorted by location
+func writeModified(w io.Writer, base []byte, mods []Modification) {
+	loc := 0
+	for _, m := range mods {
+		// untouched bytes leading up to the modification
+		_, err := w.Write(base[loc:m.Location])
+		if err != nil {
+			panic(err)
 		}
+
+		_, err = w.Write(m.Insert)
+		if err != nil {
+			panic(err)
+		}
+
+		loc = m.Location + m.Delete
 	}
 
-	err = ioutil.WriteFile(filename, output, 0666)
+	// whatever is left after the last modification, this also covers
+	// inserts that land at the very end of the base
+	_, err := w.Write(base[loc:])
 	if err != nil {
 		panic(err)
 	}
 }
+
+// keeps track of how many bytes pass through to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseSizeLimit(t *testing.T) {
+	tests := []struct {
+		limit string
+		total int64
+		want  int64
+	}{
+		{"100", 0, 100},
+		{"512K", 0, 512 << 10},
+		{"512kb", 0, 512 << 10},
+		{"1.5M", 0, 3 << 19},
+		{"2GB", 0, 2 << 30},
+		{"10B", 0, 10},
+		{"25%", 400, 100},
+		{"12.5%", 1000, 125},
+	}
+
+	for _, test := range tests {
+		l, err := parseSizeLimit(test.limit)
+		if err != nil {
+			t.Errorf("parseSizeLimit(%q) failed: %v", test.limit, err)
+			continue
+		}
+
+		if got := l.of(test.total); got != test.want {
+			t.Errorf("parseSizeLimit(%q).of(%d) = %d, want %d", test.limit, test.total, got, test.want)
+		}
+	}
+
+	for _, limit := range []string{"", "abc", "10MK", "10BB", "K", "-1", "-5%", "%", "inf", "nan", "INF%", "NaN", "1e3", "0x10", "1..5M", "."} {
+		if _, err := parseSizeLimit(limit); err == nil {
+			t.Errorf("parseSizeLimit(%q) should have failed", limit)
+		}
+	}
+}
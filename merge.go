@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// a run of bytes in a patched file, either copied from the base or new data
+// that came from the patch. a copy with length -1 runs to the end of the base
+type segment struct {
+	from   int
+	length int
+	data   []byte
+}
+
+func (s segment) literal() bool {
+	return s.from < 0
+}
+
+func mergePatches() {
+	files := append([]string{args.Positional.BaseFile, args.Positional.OtherFile}, args.Positional.Extra...)
+
+	raw := readFile(files[0])
+	noteSize(files[0]+" size", len(raw))
+
+	merged := decodePatch(raw)
+	merged.Modifications = checkModifications(merged.Modifications, -1, files[0])
+
+	for i, file := range files[1:] {
+		raw := readFile(file)
+		noteSize(file+" size", len(raw))
+
+		next := decodePatch(raw)
+
+		// older patches don't record what they produce, only check the
+		// chain lines up when both sides know
+		if merged.Result != nil && !bytes.Equal(merged.Result, next.Hash) {
+			fmt.Printf("%s doesn't apply to the output of %s\n", file, files[i])
+			if !args.Force {
+				exit(1)
+			}
+			fmt.Println("forcing through it")
+		}
+
+		mods := checkModifications(next.Modifications, -1, file)
+		merged.Modifications = composeModifications(merged.Modifications, mods)
+		merged.Result = next.Result
+		merged.Target = next.Target
+	}
+	phaseDone("read and compose")
+
+	labelPatch(&merged)
+
+	filename := args.Output
+
+	if len(filename) == 0 {
+		filename = "merged.patch"
+	}
+
+	output := encodePatch(merged)
+	writeOutput(filename, output)
+	phaseDone("write")
+	noteSize("patch size", len(output))
+
+	fmt.Printf("merged %d patches into %s (%d modifications)\n", len(files), filename, len(merged.Modifications))
+}
+
+// combines first (A to B) and second (B to C) into modifications that turn A
+// straight into C, without ever building B
+func composeModifications(first []Modification, second []Modification) []Modification {
+	segments := toSegments(first)
+
+	var result []segment
+	pos := 0 // offset into B
+	for _, m := range second {
+		var kept []segment
+		kept, segments = splitSegments(segments, m.Location-pos)
+		result = append(result, kept...)
+
+		_, segments = splitSegments(segments, m.Delete)
+
+		if len(m.Insert) != 0 {
+			result = append(result, segment{from: -1, length: len(m.Insert), data: m.Insert})
+		}
+
+		pos = m.Location + m.Delete
+	}
+	result = append(result, segments...)
+
+	return fromSegments(result)
+}
+
+// describes the output of mods as segments of the base and inserted data
+func toSegments(mods []Modification) []segment {
+	var segments []segment
+
+	loc := 0
+	for _, m := range mods {
+		if m.Location > loc {
+			segments = append(segments, segment{from: loc, length: m.Location - loc})
+		}
+
+		if len(m.Insert) != 0 {
+			segments = append(segments, segment{from: -1, length: len(m.Insert), data: m.Insert})
+		}
+
+		loc = m.Location + m.Delete
+	}
+
+	// the rest of the base, however long it turns out to be
+	return append(segments, segment{from: loc, length: -1})
+}
+
+// splits off the first n bytes worth of segments
+func splitSegments(segments []segment, n int) ([]segment, []segment) {
+	var head []segment
+
+	for n > 0 && len(segments) != 0 {
+		s := segments[0]
+
+		if s.length != -1 && s.length <= n {
+			head = append(head, s)
+			segments = segments[1:]
+			n -= s.length
+			continue
+		}
+
+		// only part of this segment fits
+		front, back := s, s
+		front.length = n
+		if s.literal() {
+			front.data = s.data[:n]
+			back.data = s.data[n:]
+		} else {
+			back.from = s.from + n
+		}
+		if s.length != -1 {
+			back.length = s.length - n
+		}
+
+		head = append(head, front)
+		segments = append([]segment{back}, segments[1:]...)
+		n = 0
+	}
+
+	return head, segments
+}
+
+// turns segments back into modifications of the base, whatever part of the
+// base is skipped over becomes a delete
+func fromSegments(segments []segment) []Modification {
+	var mods []Modification
+	var pending *Modification
+
+	flush := func() {
+		if pending != nil {
+			mods = append(mods, *pending)
+			pending = nil
+		}
+	}
+
+	loc := 0 // offset into the base
+	for _, s := range segments {
+		if s.length == 0 {
+			continue
+		}
+
+		if s.literal() {
+			if pending == nil {
+				pending = &Modification{Location: loc}
+			}
+			pending.Insert = append(pending.Insert, s.data...)
+			continue
+		}
+
+		if s.from > loc {
+			if pending == nil {
+				pending = &Modification{Location: loc}
+			}
+			pending.Delete += s.from - loc
+		}
+
+		flush()
+		loc = s.from + s.length
+	}
+	flush()
+
+	return mods
+}
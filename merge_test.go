@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// base with mods applied, the reference composition gets checked against
+func applyMods(base string, mods []Modification) string {
+	var out bytes.Buffer
+	writeModified(&out, []byte(base), mods)
+	return out.String()
+}
+
+func TestComposeModifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		first  []Modification
+		second []Modification
+		exact  bool // whether to check the result against want
+		want   []Modification
+	}{
+		{
+			name:  "both empty",
+			base:  "abcdef",
+			exact: true,
+		},
+		{
+			name:  "empty second",
+			base:  "abcdef",
+			first: []Modification{{Location: 2, Delete: 1, Insert: []byte("X")}},
+			exact: true,
+			want:  []Modification{{Location: 2, Delete: 1, Insert: []byte("X")}},
+		},
+		{
+			name:   "empty first",
+			base:   "abcdef",
+			second: []Modification{{Location: 4, Delete: 2}},
+			exact:  true,
+			want:   []Modification{{Location: 4, Delete: 2}},
+		},
+		{
+			name:   "insert at end",
+			base:   "abcdef",
+			first:  []Modification{{Location: 6, Insert: []byte("gh")}},
+			second: []Modification{{Location: 8, Insert: []byte("ij")}},
+			exact:  true,
+			want:   []Modification{{Location: 6, Insert: []byte("ghij")}},
+		},
+		{
+			name:   "insert at end of untouched base",
+			base:   "abcdef",
+			first:  []Modification{{Location: 0, Delete: 1, Insert: []byte("A")}},
+			second: []Modification{{Location: 6, Insert: []byte("!")}},
+			exact:  true,
+			want: []Modification{
+				{Location: 0, Delete: 1, Insert: []byte("A")},
+				{Location: 6, Insert: []byte("!")},
+			},
+		},
+		{
+			name:   "delete spanning segments",
+			base:   "abcdefghij",
+			first:  []Modification{{Location: 3, Delete: 2, Insert: []byte("XYZ")}},
+			second: []Modification{{Location: 1, Delete: 6}},
+			exact:  true,
+			want:   []Modification{{Location: 1, Delete: 5}},
+		},
+		{
+			name:   "second deletes first insert",
+			base:   "abcdef",
+			first:  []Modification{{Location: 3, Insert: []byte("XYZ")}},
+			second: []Modification{{Location: 3, Delete: 3}},
+			exact:  true,
+		},
+		{
+			name:   "second deletes part of first insert",
+			base:   "abcdef",
+			first:  []Modification{{Location: 3, Insert: []byte("XYZ")}},
+			second: []Modification{{Location: 4, Delete: 1, Insert: []byte("q")}},
+			exact:  true,
+			want:   []Modification{{Location: 3, Insert: []byte("XqZ")}},
+		},
+		{
+			name:   "second undoes first delete",
+			base:   "abcdef",
+			first:  []Modification{{Location: 2, Delete: 2}},
+			second: []Modification{{Location: 2, Insert: []byte("cd")}},
+		},
+		{
+			name: "several of each",
+			base: "the quick brown fox jumps over the lazy dog",
+			first: []Modification{
+				{Location: 4, Delete: 5, Insert: []byte("slow")},
+				{Location: 16, Delete: 3, Insert: []byte("cat")},
+				{Location: 43, Insert: []byte("!")},
+			},
+			second: []Modification{
+				{Location: 0, Delete: 4},
+				{Location: 9, Delete: 6, Insert: []byte("red")},
+				{Location: 40, Delete: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := composeModifications(test.first, test.second)
+
+		if err := validateModifications(got, len(test.base)); err != nil {
+			t.Errorf("%s: composed modifications are invalid: %v", test.name, err)
+			continue
+		}
+
+		want := applyMods(applyMods(test.base, test.first), test.second)
+		if out := applyMods(test.base, got); out != want {
+			t.Errorf("%s: composed patch produces %q, want %q", test.name, out, want)
+		}
+
+		if test.exact && !sameMods(got, test.want) {
+			t.Errorf("%s: got modifications %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func sameMods(a []Modification, b []Modification) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !sameMod(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
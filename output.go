@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// every file patcher produces goes through here
+func writeOutput(filename string, data []byte) {
+	writeFile(filename, data)
+
+	// no sidecar for /dev/null and friends
+	if info, err := os.Stat(filename); args.Checksum && err == nil && info.Mode().IsRegular() {
+		writeChecksum(filename, data)
+	}
+}
+
+// writes <filename>.sha256 next to filename in the same format as sha256sum
+// so `sha256sum -c` can verify it from that directory
+func writeChecksum(filename string, data []byte) {
+	_, name := filepath.Split(filename)
+	line := fmt.Sprintf("%x  %s\n", sha256.Sum256(data), name)
+
+	writeFile(filename+".sha256", []byte(line))
+}
+
+// writes data to a temp file next to filename, syncs it and renames it into
+// place, so filename is either the old file or the complete new one even if
+// the machine goes down halfway through
+func writeDurably(filename string, data []byte) error {
+	// devices, pipes and the like (/dev/null, /dev/stdout) can't be renamed
+	// over, they just get written to
+	if info, err := os.Stat(filename); err == nil && !info.Mode().IsRegular() {
+		return ioutil.WriteFile(filename, data, 0666)
+	}
+
+	// replace what a symlink points at rather than the link itself
+	if info, err := os.Lstat(filename); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(filename)
+		if err != nil {
+			// dangling, let the OS create whatever it points at
+			return ioutil.WriteFile(filename, data, 0666)
+		}
+		filename = target
+	}
+
+	dir, name := filepath.Split(filename)
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	tmp, err := createTemp(dir, name)
+	if err != nil {
+		return err
+	}
+
+	// only still around if something below failed
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	// keep the permissions of whatever is being replaced, new files get
+	// whatever the umask allows just like WriteFile would
+	if info, statErr := os.Stat(filename); err == nil && statErr == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), filename)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// like ioutil.TempFile, but created with 0666 so the umask decides the
+// permissions the way it does for any other new file
+func createTemp(dir string, name string) (*os.File, error) {
+	for i := 0; ; i++ {
+		tmpName := filepath.Join(dir, fmt.Sprintf(".%s.%d.%d.tmp", name, os.Getpid(), i))
+
+		f, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+
+		return f, err
+	}
+}
+
+// makes the rename itself durable
+func syncDir(dir string) error {
+	// windows can't open directories for syncing, renames there are
+	// journaled by NTFS anyway
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+// no getrusage here, printSummary falls back to what the runtime knows
+func peakMemory() (int64, bool) {
+	return 0, false
+}
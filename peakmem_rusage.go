@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// the process's peak resident set size in bytes
+func peakMemory() (int64, bool) {
+	var usage syscall.Rusage
+
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	if err != nil {
+		return 0, false
+	}
+
+	// darwin reports bytes, everyone else kilobytes
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss), true
+	}
+
+	return int64(usage.Maxrss) * 1024, true
+}
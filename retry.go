@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// every name --retry-on understands, retryableErrors holds the ones the
+// platform actually has
+var retryableNames = []string{"EAGAIN", "EBUSY", "ECONNRESET", "EINTR", "EIO", "ESTALE", "ETIMEDOUT"}
+
+// the errors named by --retry-on, filled in by parseRetryOn
+var retryOn []error
+
+func parseRetryOn() {
+	retryOn = nil
+
+	for _, name := range strings.Split(args.RetryOn, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+
+		known := false
+		for _, n := range retryableNames {
+			known = known || n == name
+		}
+		if !known {
+			fmt.Printf("unknown error %s for --retry-on\n", name)
+			exit(1)
+		}
+
+		// a name this platform doesn't have can't come up either
+		if e, ok := retryableErrors[name]; ok {
+			retryOn = append(retryOn, e)
+		}
+	}
+}
+
+func retryable(err error) bool {
+	for _, e := range retryOn {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runs op until it works, fails with something not worth retrying, or runs
+// out of --retries. the wait doubles after every attempt
+func withRetry(what string, op func() error) error {
+	delay := args.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= args.Retries || !retryable(err) {
+			return err
+		}
+
+		fmt.Printf("%s failed: %v, retrying in %s\n", what, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func readFile(filename string) []byte {
+	var data []byte
+
+	err := withRetry("reading "+filename, func() error {
+		var err error
+		data, err = ioutil.ReadFile(filename)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+func writeFile(filename string, data []byte) {
+	err := withRetry("writing "+filename, func() error {
+		return writeDurably(filename, data)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
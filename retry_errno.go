@@ -0,0 +1,16 @@
+//go:build !plan9
+
+package main
+
+import "syscall"
+
+// errors that can go away on their own, mostly seen on network filesystems
+var retryableErrors = map[string]error{
+	"EAGAIN":     syscall.EAGAIN,
+	"EBUSY":      syscall.EBUSY,
+	"ECONNRESET": syscall.ECONNRESET,
+	"EINTR":      syscall.EINTR,
+	"EIO":        syscall.EIO,
+	"ESTALE":     syscall.ESTALE,
+	"ETIMEDOUT":  syscall.ETIMEDOUT,
+}
@@ -0,0 +1,5 @@
+package main
+
+// plan9 errors are strings rather than errnos, none of the names map onto
+// anything there
+var retryableErrors = map[string]error{}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// everything --strict insists on before a single byte gets written. the
+// patch has already been fully decompressed and decoded by readPatch, which
+// also verified the zlib checksum, so this is about whether it fits the base
+func strictCheck(patch Patch, base []byte, h []byte) {
+	failed := false
+	fail := func(format string, a ...interface{}) {
+		failed = true
+		fmt.Printf("strict: "+format+"\n", a...)
+	}
+
+	if len(patch.Hash) == 0 {
+		fail("patch has no base hash")
+	} else if !bytes.Equal(patch.Hash, h) {
+		fail("base hash %x doesn't match the %x the patch expects", h, patch.Hash)
+	}
+
+	if patch.Size != 0 && patch.Size != len(base) {
+		fail("base is %d bytes, the patch was built from %d bytes", len(base), patch.Size)
+	}
+
+	err := validateModifications(patch.Modifications, len(base))
+	if err != nil {
+		fail("%v", err)
+	} else {
+		// stricter than validateModifications, every modification has to
+		// do something and start after the previous one
+		prev := -1
+		for i, m := range patch.Modifications {
+			if m.Delete == 0 && len(m.Insert) == 0 {
+				fail("modification %d at %d doesn't change anything", i, m.Location)
+			}
+			if m.Location <= prev {
+				fail("modification %d at %d doesn't come after the previous one at %d", i, m.Location, prev)
+			}
+			prev = m.Location
+		}
+	}
+
+	// only worth running the modifications once they're known to be sane
+	if !failed {
+		if len(patch.Result) == 0 {
+			fail("patch has no result hash to verify the output against")
+		} else {
+			hasher := sha256.New()
+			writeModified(hasher, base, patch.Modifications)
+
+			if result := hasher.Sum(nil); !bytes.Equal(result, patch.Result) {
+				fail("output would hash to %x instead of %x", result, patch.Result)
+			}
+		}
+	}
+
+	if failed {
+		fmt.Println("strict checks failed, nothing was written")
+		exit(exitInvalidPatch)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+type summaryLine struct {
+	name  string
+	value string
+}
+
+// collected as the run goes along, only printed with --summary
+var (
+	runStart     = time.Now()
+	phaseStart   = runStart
+	summaryTimes []summaryLine
+	summarySizes []summaryLine
+)
+
+// records how long it's been since the previous phase finished
+func phaseDone(name string) {
+	now := time.Now()
+	summaryTimes = append(summaryTimes, summaryLine{name, now.Sub(phaseStart).Round(time.Microsecond).String()})
+	phaseStart = now
+}
+
+func noteSize(name string, n int) {
+	summarySizes = append(summarySizes, summaryLine{name, fmt.Sprintf("%d bytes", n)})
+}
+
+// how many times smaller the patch is than the file it produces
+func notePatchRatio(name string, patchSize int, targetSize int) {
+	if patchSize == 0 {
+		return
+	}
+
+	ratio := float64(targetSize) / float64(patchSize)
+	summarySizes = append(summarySizes, summaryLine{name, fmt.Sprintf("%.2fx", ratio)})
+}
+
+func printSummary() {
+	if !args.Summary {
+		return
+	}
+
+	lines := append([]summaryLine{}, summaryTimes...)
+	lines = append(lines, summaryLine{"total time", time.Since(runStart).Round(time.Microsecond).String()})
+	lines = append(lines, summarySizes...)
+	if peak, ok := peakMemory(); ok {
+		lines = append(lines, summaryLine{"peak memory", fmt.Sprintf("%.1f MiB", float64(peak)/(1<<20))})
+	} else {
+		// not the peak, but what the runtime has taken from the OS so far
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		lines = append(lines, summaryLine{"memory from OS", fmt.Sprintf("%.1f MiB", float64(mem.Sys)/(1<<20))})
+	}
+
+	width := 0
+	for _, l := range lines {
+		if len(l.name) > width {
+			width = len(l.name)
+		}
+	}
+
+	fmt.Println("summary:")
+	for _, l := range lines {
+		fmt.Printf("  %-*s  %s\n", width, l.name, l.value)
+	}
+}
+
+// os.Exit skips deferred calls, so anything ending the run early comes
+// through here to still get its summary
+func exit(code int) {
+	printSummary()
+	os.Exit(code)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// makes sure modifications are sorted, don't overlap and stay inside the
+// base, writeModified depends on all three. baseLen < 0 means the base isn't
+// known so only ordering gets checked
+func validateModifications(mods []Modification, baseLen int) error {
+	end := 0
+	for i, m := range mods {
+		if m.Location < 0 || m.Delete < 0 {
+			return fmt.Errorf("modification %d has a negative location or delete count", i)
+		}
+
+		if m.Location < end {
+			return fmt.Errorf("modification %d at %d overlaps or comes before the previous one ending at %d", i, m.Location, end)
+		}
+
+		end = m.Location + m.Delete
+		if baseLen >= 0 && end > baseLen {
+			return fmt.Errorf("modification %d at %d runs past the end of the %d byte base", i, m.Location, baseLen)
+		}
+	}
+
+	return nil
+}
+
+// best effort fix up of malformed modifications: sorts them, folds
+// overlapping ones together and trims anything hanging past the base
+func repairModifications(mods []Modification, baseLen int) []Modification {
+	sorted := make([]Modification, 0, len(mods))
+	for _, m := range mods {
+		if m.Location < 0 || m.Delete < 0 {
+			continue
+		}
+		sorted = append(sorted, m)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Location < sorted[j].Location
+	})
+
+	var repaired []Modification
+	for _, m := range sorted {
+		if baseLen >= 0 {
+			if m.Location > baseLen {
+				continue
+			}
+			if m.Location+m.Delete > baseLen {
+				m.Delete = baseLen - m.Location
+			}
+		}
+
+		last := len(repaired) - 1
+		if last >= 0 && m.Location < modEnd(repaired[last]) {
+			// overlap, one modification covering both
+			prev := &repaired[last]
+			if modEnd(m) > modEnd(*prev) {
+				prev.Delete = modEnd(m) - prev.Location
+			}
+			prev.Insert = append(append([]byte{}, prev.Insert...), m.Insert...)
+			continue
+		}
+
+		repaired = append(repaired, m)
+	}
+
+	return repaired
+}
+
+// validates the modifications read from filename, either repairing them or
+// bailing out depending on --repair
+func checkModifications(mods []Modification, baseLen int, filename string) []Modification {
+	err := validateModifications(mods, baseLen)
+	if err == nil {
+		return mods
+	}
+
+	if !args.Repair {
+		fmt.Printf("%s is malformed: %v\n", filename, err)
+		exit(exitInvalidPatch)
+	}
+
+	fmt.Printf("%s is malformed: %v, repairing it\n", filename, err)
+
+	return repairModifications(mods, baseLen)
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestValidateModifications(t *testing.T) {
+	tests := []struct {
+		name    string
+		mods    []Modification
+		baseLen int
+		valid   bool
+	}{
+		{"empty", nil, 0, true},
+		{"sorted", []Modification{{Location: 1, Delete: 2}, {Location: 3, Insert: []byte("x")}}, 5, true},
+		{"insert at end", []Modification{{Location: 5, Insert: []byte("x")}}, 5, true},
+		{"unknown base", []Modification{{Location: 50, Delete: 2}}, -1, true},
+		{"unsorted", []Modification{{Location: 3, Delete: 1}, {Location: 1, Delete: 1}}, 5, false},
+		{"overlapping", []Modification{{Location: 1, Delete: 3}, {Location: 2, Delete: 1}}, 5, false},
+		{"past the end", []Modification{{Location: 4, Delete: 2}}, 5, false},
+		{"negative", []Modification{{Location: -1}}, 5, false},
+	}
+
+	for _, test := range tests {
+		err := validateModifications(test.mods, test.baseLen)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestRepairModifications(t *testing.T) {
+	tests := []struct {
+		name    string
+		mods    []Modification
+		baseLen int
+		want    []Modification
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "already fine",
+			mods:    []Modification{{Location: 1, Delete: 1}, {Location: 4, Insert: []byte("x")}},
+			baseLen: 5,
+			want:    []Modification{{Location: 1, Delete: 1}, {Location: 4, Insert: []byte("x")}},
+		},
+		{
+			name:    "unsorted",
+			mods:    []Modification{{Location: 4, Insert: []byte("x")}, {Location: 1, Delete: 1}},
+			baseLen: 5,
+			want:    []Modification{{Location: 1, Delete: 1}, {Location: 4, Insert: []byte("x")}},
+		},
+		{
+			name:    "overlapping get folded together",
+			mods:    []Modification{{Location: 1, Delete: 3, Insert: []byte("a")}, {Location: 2, Delete: 4, Insert: []byte("b")}},
+			baseLen: 10,
+			want:    []Modification{{Location: 1, Delete: 5, Insert: []byte("ab")}},
+		},
+		{
+			name:    "contained in the previous one",
+			mods:    []Modification{{Location: 1, Delete: 6}, {Location: 2, Delete: 1, Insert: []byte("b")}},
+			baseLen: 10,
+			want:    []Modification{{Location: 1, Delete: 6, Insert: []byte("b")}},
+		},
+		{
+			name:    "delete past the end is trimmed",
+			mods:    []Modification{{Location: 3, Delete: 10}},
+			baseLen: 5,
+			want:    []Modification{{Location: 3, Delete: 2}},
+		},
+		{
+			name:    "past the end and negative are dropped",
+			mods:    []Modification{{Location: -2, Delete: 1}, {Location: 2, Delete: -1}, {Location: 9, Insert: []byte("x")}, {Location: 0, Delete: 1}},
+			baseLen: 5,
+			want:    []Modification{{Location: 0, Delete: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		got := repairModifications(test.mods, test.baseLen)
+
+		if !sameMods(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+
+		if err := validateModifications(got, test.baseLen); err != nil {
+			t.Errorf("%s: repaired modifications are still invalid: %v", test.name, err)
+		}
+	}
+}